@@ -0,0 +1,330 @@
+// discovery.go
+package main
+
+import (
+	"fmt"
+	"os"
+	"path/filepath"
+	"regexp"
+	"strings"
+
+	"github.com/spf13/afero"
+)
+
+// DiscoveryOptions controls how files are found inside an r_* folder.
+type DiscoveryOptions struct {
+	Recursive      bool
+	Include        []globPattern
+	Exclude        []globPattern
+	FollowSymlinks bool
+}
+
+// discoveredFile is one file found under an r_* folder, identified by its
+// path relative to the folder root so nested files can be addressed and
+// logged without leaking the folder's absolute path.
+type discoveredFile struct {
+	RelPath string
+	Info    os.FileInfo
+	// Skipped holds a human-readable reason when the file should be
+	// recorded with a "skipped" status instead of hashed, e.g. an
+	// untraversed symlink.
+	Skipped string
+}
+
+// discoverFiles lists the files to process under folder. Non-recursive
+// mode mirrors the tool's original top-level os.ReadDir scan; recursive
+// mode walks subdirectories by hand so directory symlinks can be given
+// their own traversal decision instead of falling through to afero.Walk,
+// which (like filepath.Walk) lstats entries and would otherwise hand a
+// symlinked directory to processFile as if it were a regular file.
+// include/exclude glob patterns are matched against each file's path
+// relative to folder.
+func discoverFiles(fs afero.Fs, folder string, opts DiscoveryOptions) ([]discoveredFile, error) {
+	if !opts.Recursive {
+		entries, err := afero.ReadDir(fs, folder)
+		if err != nil {
+			return nil, fmt.Errorf("read folder: %w", err)
+		}
+
+		var files []discoveredFile
+		for _, de := range entries {
+			if isBookkeepingFile(de.Name()) {
+				continue
+			}
+			// Non-recursive mode never descends into directories, so a
+			// symlinked directory is dropped exactly like a real one.
+			if de.IsDir() || isDirSymlink(fs, filepath.Join(folder, de.Name()), de) {
+				continue
+			}
+			if !matchesFilters(de.Name(), opts.Include, opts.Exclude) {
+				continue
+			}
+			files = append(files, newDiscoveredFile(de.Name(), de, opts.FollowSymlinks))
+		}
+		return files, nil
+	}
+
+	// symlinkGuard tracks the folder's real (symlink-resolved) path plus
+	// every directory symlink target visited so far, so -follow-symlinks
+	// can refuse to escape the folder root or loop forever on a cycle.
+	guard := newSymlinkGuard(folder)
+	return discoverRecursive(fs, folder, folder, opts, guard)
+}
+
+// discoverRecursive lists files under dir, recording each discoveredFile's
+// RelPath relative to root. Directory symlinks are only traversed when
+// opts.FollowSymlinks is set, and even then only if guard confirms the
+// target stays under root and hasn't already been visited; otherwise
+// they're recorded with a skipped status rather than being silently
+// treated as files or walked into forever.
+func discoverRecursive(fs afero.Fs, root, dir string, opts DiscoveryOptions, guard *symlinkGuard) ([]discoveredFile, error) {
+	entries, err := afero.ReadDir(fs, dir)
+	if err != nil {
+		return nil, fmt.Errorf("read folder: %w", err)
+	}
+
+	var files []discoveredFile
+	for _, de := range entries {
+		if isBookkeepingFile(de.Name()) {
+			continue
+		}
+		p := filepath.Join(dir, de.Name())
+
+		if isDirSymlink(fs, p, de) {
+			rel, err := relSlash(root, p)
+			if err != nil {
+				return nil, err
+			}
+			if !opts.FollowSymlinks {
+				if matchesFilters(rel, opts.Include, opts.Exclude) {
+					files = append(files, discoveredFile{
+						RelPath: rel,
+						Info:    de,
+						Skipped: "symlinked directory not followed (-follow-symlinks=false)",
+					})
+				}
+				continue
+			}
+			if reason := guard.checkAndVisit(p); reason != "" {
+				if matchesFilters(rel, opts.Include, opts.Exclude) {
+					files = append(files, discoveredFile{RelPath: rel, Info: de, Skipped: reason})
+				}
+				continue
+			}
+			nested, err := discoverRecursive(fs, root, p, opts, guard)
+			if err != nil {
+				return nil, err
+			}
+			files = append(files, nested...)
+			continue
+		}
+
+		if de.IsDir() {
+			nested, err := discoverRecursive(fs, root, p, opts, guard)
+			if err != nil {
+				return nil, err
+			}
+			files = append(files, nested...)
+			continue
+		}
+
+		rel, err := relSlash(root, p)
+		if err != nil {
+			return nil, err
+		}
+		if !matchesFilters(rel, opts.Include, opts.Exclude) {
+			continue
+		}
+		files = append(files, newDiscoveredFile(rel, de, opts.FollowSymlinks))
+	}
+	return files, nil
+}
+
+// symlinkGuard enforces the two hazards that come with -follow-symlinks:
+// a symlink pointing outside the folder root (escape), and a symlink
+// that loops back on a directory already visited (cycle). Both are
+// decided on the symlink-resolved real path rather than the logical
+// one, since two different logical paths can resolve to the same
+// directory on disk.
+type symlinkGuard struct {
+	rootReal string
+	haveRoot bool
+	visited  map[string]struct{}
+}
+
+func newSymlinkGuard(root string) *symlinkGuard {
+	g := &symlinkGuard{visited: map[string]struct{}{}}
+	if real, ok := resolveReal(root); ok {
+		g.rootReal = real
+		g.haveRoot = true
+		g.visited[real] = struct{}{}
+	}
+	return g
+}
+
+// checkAndVisit returns a non-empty skip reason if path's symlink target
+// escapes the folder root or has already been visited; otherwise it
+// records the target as visited and returns "" to allow descent.
+func (g *symlinkGuard) checkAndVisit(path string) string {
+	real, ok := resolveReal(path)
+	switch {
+	case !ok:
+		return "symlinked directory not followed (could not resolve target)"
+	case !g.haveRoot:
+		return "symlinked directory not followed (could not resolve folder root)"
+	case !withinRoot(g.rootReal, real):
+		return "symlinked directory not followed (target escapes folder root)"
+	}
+	if _, seen := g.visited[real]; seen {
+		return "symlinked directory not followed (cycle detected)"
+	}
+	g.visited[real] = struct{}{}
+	return ""
+}
+
+// resolveReal resolves path's symlink chain to a canonical absolute path.
+// It only succeeds against a real (disk-backed) filesystem; afero's
+// in-memory Fs implementations never produce symlinks in the first
+// place, so a failure here simply means there was nothing to resolve.
+func resolveReal(path string) (string, bool) {
+	real, err := filepath.EvalSymlinks(path)
+	if err != nil {
+		return "", false
+	}
+	return real, true
+}
+
+// withinRoot reports whether real is rootReal itself or a descendant of it.
+func withinRoot(rootReal, real string) bool {
+	rel, err := filepath.Rel(rootReal, real)
+	if err != nil {
+		return false
+	}
+	if rel == ".." || strings.HasPrefix(rel, ".."+string(filepath.Separator)) {
+		return false
+	}
+	return true
+}
+
+// relSlash returns path relative to root with forward slashes, for
+// glob matching and for storing in discoveredFile.RelPath.
+func relSlash(root, path string) (string, error) {
+	rel, err := filepath.Rel(root, path)
+	if err != nil {
+		return "", err
+	}
+	return filepath.ToSlash(rel), nil
+}
+
+// isDirSymlink reports whether info - as returned by a listing that lstats
+// its entries, so symlinks keep their own mode bit - is a symlink whose
+// target is a directory.
+func isDirSymlink(fs afero.Fs, path string, info os.FileInfo) bool {
+	if info.Mode()&os.ModeSymlink == 0 {
+		return false
+	}
+	target, err := fs.Stat(path)
+	if err != nil {
+		return false
+	}
+	return target.IsDir()
+}
+
+func isBookkeepingFile(name string) bool {
+	switch name {
+	case lockFileName, "log.json", "log.tmp":
+		return true
+	}
+	return false
+}
+
+func newDiscoveredFile(relPath string, info os.FileInfo, followSymlinks bool) discoveredFile {
+	df := discoveredFile{RelPath: relPath, Info: info}
+	if info.Mode()&os.ModeSymlink != 0 && !followSymlinks {
+		df.Skipped = "symlink not followed (-follow-symlinks=false)"
+	}
+	return df
+}
+
+// globPattern is a compiled -include/-exclude entry.
+type globPattern struct {
+	raw string
+	re  *regexp.Regexp
+}
+
+// compileGlobs parses a comma-separated list of glob patterns. Patterns
+// use path.Match semantics (*, ?) plus "**" to match across path
+// separators, for matching nested paths discovered with -recursive.
+func compileGlobs(spec string) ([]globPattern, error) {
+	spec = strings.TrimSpace(spec)
+	if spec == "" {
+		return nil, nil
+	}
+
+	var patterns []globPattern
+	for _, part := range strings.Split(spec, ",") {
+		raw := strings.TrimSpace(part)
+		if raw == "" {
+			continue
+		}
+		re, err := globToRegexp(raw)
+		if err != nil {
+			return nil, fmt.Errorf("invalid glob %q: %w", raw, err)
+		}
+		patterns = append(patterns, globPattern{raw: raw, re: re})
+	}
+	return patterns, nil
+}
+
+// globToRegexp compiles a glob pattern to an anchored regexp: "*" and "?"
+// behave like path.Match (never crossing "/"), and "**" matches any
+// number of path segments, including none.
+func globToRegexp(pattern string) (*regexp.Regexp, error) {
+	var b strings.Builder
+	b.WriteString("^")
+
+	runes := []rune(pattern)
+	for i := 0; i < len(runes); i++ {
+		switch runes[i] {
+		case '*':
+			if i+1 < len(runes) && runes[i+1] == '*' {
+				if i+2 < len(runes) && runes[i+2] == '/' {
+					b.WriteString("(?:.*/)?")
+					i += 2
+				} else {
+					b.WriteString(".*")
+					i++
+				}
+			} else {
+				b.WriteString("[^/]*")
+			}
+		case '?':
+			b.WriteString("[^/]")
+		default:
+			b.WriteString(regexp.QuoteMeta(string(runes[i])))
+		}
+	}
+
+	b.WriteString("$")
+	return regexp.Compile(b.String())
+}
+
+// matchesFilters reports whether relPath should be processed given the
+// include/exclude glob lists. An empty include list matches everything;
+// exclude always takes priority over include.
+func matchesFilters(relPath string, include, exclude []globPattern) bool {
+	for _, pat := range exclude {
+		if pat.re.MatchString(relPath) {
+			return false
+		}
+	}
+	if len(include) == 0 {
+		return true
+	}
+	for _, pat := range include {
+		if pat.re.MatchString(relPath) {
+			return true
+		}
+	}
+	return false
+}
@@ -0,0 +1,57 @@
+// hash.go
+package main
+
+import (
+	"crypto/md5"
+	"crypto/sha256"
+	"fmt"
+	"hash"
+	"sort"
+	"strings"
+
+	"golang.org/x/crypto/blake2b"
+)
+
+// hashFactories maps a -hash flag name to a constructor for that
+// algorithm's hash.Hash. Add a new algorithm here without touching
+// processFile.
+var hashFactories = map[string]func() hash.Hash{
+	"md5":    md5.New,
+	"sha256": sha256.New,
+	"blake2b": func() hash.Hash {
+		h, _ := blake2b.New256(nil)
+		return h
+	},
+}
+
+// parseHashAlgorithms validates the comma-separated -hash spec and
+// returns the deduplicated, sorted list of algorithm names, defaulting
+// to md5 when spec is empty.
+func parseHashAlgorithms(spec string) ([]string, error) {
+	if strings.TrimSpace(spec) == "" {
+		return []string{"md5"}, nil
+	}
+
+	seen := map[string]bool{}
+	var names []string
+	for _, part := range strings.Split(spec, ",") {
+		name := strings.ToLower(strings.TrimSpace(part))
+		if name == "" {
+			continue
+		}
+		if _, ok := hashFactories[name]; !ok {
+			return nil, fmt.Errorf("unknown hash algorithm %q", name)
+		}
+		if seen[name] {
+			continue
+		}
+		seen[name] = true
+		names = append(names, name)
+	}
+
+	if len(names) == 0 {
+		return []string{"md5"}, nil
+	}
+	sort.Strings(names)
+	return names, nil
+}
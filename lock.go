@@ -0,0 +1,66 @@
+// lock.go
+package main
+
+import (
+	"encoding/json"
+	"fmt"
+	"os"
+	"path/filepath"
+	"time"
+)
+
+// lockFileName is the advisory lockfile created inside every folder while
+// it is being processed.
+const lockFileName = ".processing.lock"
+
+// lockInfo is written into the lockfile so operators can tell who holds
+// it, e.g. after a crash leaves a stale r_* folder behind.
+type lockInfo struct {
+	PID      int       `json:"pid"`
+	Hostname string    `json:"hostname"`
+	Started  time.Time `json:"started"`
+}
+
+// folderLock is a held advisory lock on a folder's lockfile.
+type folderLock struct {
+	file *os.File
+	path string
+}
+
+// acquireFolderLock takes a non-blocking advisory lock on folder's
+// lockfile. If the lock is already held by another process, ok is false
+// and err is nil so callers can skip the folder instead of treating it
+// as a processing error.
+func acquireFolderLock(folder string) (lock *folderLock, ok bool, err error) {
+	path := filepath.Join(folder, lockFileName)
+	f, err := os.OpenFile(path, os.O_CREATE|os.O_RDWR, 0o644)
+	if err != nil {
+		return nil, false, fmt.Errorf("open lockfile: %w", err)
+	}
+
+	if err := lockFileNonBlocking(f); err != nil {
+		f.Close()
+		if err == errLockHeld {
+			return nil, false, nil
+		}
+		return nil, false, fmt.Errorf("lock %s: %w", path, err)
+	}
+
+	hostname, _ := os.Hostname()
+	info := lockInfo{PID: os.Getpid(), Hostname: hostname, Started: time.Now().UTC()}
+	if data, err := json.MarshalIndent(info, "", "  "); err == nil {
+		if err := f.Truncate(0); err == nil {
+			f.WriteAt(data, 0)
+			f.Sync()
+		}
+	}
+
+	return &folderLock{file: f, path: path}, true, nil
+}
+
+// Release drops the lock and removes the lockfile.
+func (l *folderLock) Release() {
+	unlockFile(l.file)
+	l.file.Close()
+	os.Remove(l.path)
+}
@@ -0,0 +1,27 @@
+//go:build !windows
+
+// lock_unix.go
+package main
+
+import (
+	"errors"
+	"os"
+	"syscall"
+)
+
+// errLockHeld signals that another process already holds the lock.
+var errLockHeld = errors.New("lock already held")
+
+// lockFileNonBlocking takes an exclusive, non-blocking flock on f.
+func lockFileNonBlocking(f *os.File) error {
+	err := syscall.Flock(int(f.Fd()), syscall.LOCK_EX|syscall.LOCK_NB)
+	if err == syscall.EWOULDBLOCK {
+		return errLockHeld
+	}
+	return err
+}
+
+// unlockFile releases a lock previously taken by lockFileNonBlocking.
+func unlockFile(f *os.File) {
+	syscall.Flock(int(f.Fd()), syscall.LOCK_UN)
+}
@@ -0,0 +1,33 @@
+//go:build windows
+
+// lock_windows.go
+package main
+
+import (
+	"errors"
+	"os"
+
+	"golang.org/x/sys/windows"
+)
+
+// errLockHeld signals that another process already holds the lock.
+var errLockHeld = errors.New("lock already held")
+
+// lockFileNonBlocking takes an exclusive, non-blocking lock on f via
+// LockFileEx, mirroring the semantics of Unix flock(LOCK_EX|LOCK_NB).
+func lockFileNonBlocking(f *os.File) error {
+	handle := windows.Handle(f.Fd())
+	var overlapped windows.Overlapped
+	err := windows.LockFileEx(handle, windows.LOCKFILE_EXCLUSIVE_LOCK|windows.LOCKFILE_FAIL_IMMEDIATELY, 0, 1, 0, &overlapped)
+	if err == windows.ERROR_LOCK_VIOLATION {
+		return errLockHeld
+	}
+	return err
+}
+
+// unlockFile releases a lock previously taken by lockFileNonBlocking.
+func unlockFile(f *os.File) {
+	handle := windows.Handle(f.Fd())
+	var overlapped windows.Overlapped
+	windows.UnlockFileEx(handle, 0, 1, 0, &overlapped)
+}
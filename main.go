@@ -2,11 +2,11 @@
 package main
 
 import (
-	"crypto/md5"
 	"encoding/hex"
 	"encoding/json"
 	"flag"
 	"fmt"
+	"hash"
 	"io"
 	"os"
 	"path/filepath"
@@ -15,40 +15,104 @@ import (
 	"strings"
 	"sync"
 	"time"
+
+	"github.com/spf13/afero"
 )
 
 type LogEntry struct {
-	Filename  string `json:"filename"`
-	Status    string `json:"status"`
-	MD5       string `json:"md5,omitempty"`
-	Error     string `json:"error,omitempty"`
-	Timestamp string `json:"timestamp"`
+	Filename  string            `json:"filename"`
+	Status    string            `json:"status"`
+	MD5       string            `json:"md5,omitempty"`
+	Hashes    map[string]string `json:"hashes,omitempty"`
+	Error     string            `json:"error,omitempty"`
+	Timestamp string            `json:"timestamp"`
+	Size      int64             `json:"size,omitempty"`
+	ModTime   time.Time         `json:"mod_time,omitempty"`
 }
 
 func main() {
 	inputDir := flag.String("input", "./input", "input directory containing r_* folders")
 	concurrency := flag.Int("concurrency", runtime.NumCPU(), "max concurrent file processors per folder")
+	folderConcurrency := flag.Int("folder-concurrency", runtime.NumCPU(), "max folders processed in parallel")
+	maxInFlight := flag.Int("max-inflight", 0, "process-wide cap on concurrent file processors across all folders (default: concurrency * folder-concurrency)")
+	hashSpec := flag.String("hash", "md5", "comma-separated hash algorithms to compute per file (md5,sha256,blake2b)")
+	dryRun := flag.Bool("dry-run", false, "process files read-only: compute hashes and logs but write/rename nothing")
+	eventsPath := flag.String("events", "", "write newline-delimited JSON events to this path ('-' for stdout) instead of human-readable text")
+	resume := flag.Bool("resume", true, "skip re-hashing files whose size+mtime still match a prior log.json/log.tmp in the folder")
+	recursive := flag.Bool("recursive", false, "recurse into subdirectories instead of only scanning each folder's top level")
+	includeSpec := flag.String("include", "", "comma-separated glob patterns (relative to each r_* folder, ** supported) to include; default: everything")
+	excludeSpec := flag.String("exclude", "", "comma-separated glob patterns (relative to each r_* folder, ** supported) to exclude")
+	followSymlinks := flag.Bool("follow-symlinks", false, "follow symlinks during discovery instead of recording them with a skipped status")
 	verbose := flag.Bool("v", false, "verbose logging")
 	flag.Parse()
 
 	if *concurrency <= 0 {
 		*concurrency = 1
 	}
+	if *folderConcurrency <= 0 {
+		*folderConcurrency = 1
+	}
+	if *maxInFlight <= 0 {
+		*maxInFlight = *concurrency * *folderConcurrency
+	}
 
-	if *verbose {
-		fmt.Printf("Scanning input dir: %s (concurrency per folder: %d)\n", *inputDir, *concurrency)
+	hashNames, err := parseHashAlgorithms(*hashSpec)
+	if err != nil {
+		fmt.Fprintln(os.Stderr, "ERROR:", err)
+		os.Exit(1)
 	}
 
-	err := processAll(*inputDir, *concurrency, *verbose)
+	includePatterns, err := compileGlobs(*includeSpec)
 	if err != nil {
 		fmt.Fprintln(os.Stderr, "ERROR:", err)
 		os.Exit(1)
 	}
+	excludePatterns, err := compileGlobs(*excludeSpec)
+	if err != nil {
+		fmt.Fprintln(os.Stderr, "ERROR:", err)
+		os.Exit(1)
+	}
+	disc := DiscoveryOptions{
+		Recursive:      *recursive,
+		Include:        includePatterns,
+		Exclude:        excludePatterns,
+		FollowSymlinks: *followSymlinks,
+	}
+
+	if *verbose {
+		fmt.Printf("Scanning input dir: %s (folder concurrency: %d, per-folder concurrency: %d, max in-flight: %d, hashes: %s)\n",
+			*inputDir, *folderConcurrency, *concurrency, *maxInFlight, strings.Join(hashNames, ","))
+	}
+
+	var fs afero.Fs = afero.NewOsFs()
+	if *dryRun {
+		fs = afero.NewReadOnlyFs(fs)
+	}
+
+	var reporter Reporter = &TextReporter{Verbose: *verbose}
+	if *eventsPath != "" {
+		w := os.Stdout
+		if *eventsPath != "-" {
+			f, err := os.Create(*eventsPath)
+			if err != nil {
+				fmt.Fprintln(os.Stderr, "ERROR:", fmt.Errorf("open events file: %w", err))
+				os.Exit(1)
+			}
+			defer f.Close()
+			w = f
+		}
+		reporter = NewJSONReporter(w)
+	}
+
+	if err := processAll(fs, reporter, *inputDir, *concurrency, *folderConcurrency, *maxInFlight, hashNames, *resume, disc, *verbose); err != nil {
+		fmt.Fprintln(os.Stderr, "ERROR:", err)
+		os.Exit(1)
+	}
 }
 
-func processAll(inputDir string, concurrency int, verbose bool) error {
+func processAll(fs afero.Fs, reporter Reporter, inputDir string, concurrency, folderConcurrency, maxInFlight int, hashNames []string, resume bool, disc DiscoveryOptions, verbose bool) error {
 	// Ensure input directory exists
-	info, err := os.Stat(inputDir)
+	info, err := fs.Stat(inputDir)
 	if err != nil {
 		return fmt.Errorf("input dir check: %w", err)
 	}
@@ -57,7 +121,7 @@ func processAll(inputDir string, concurrency int, verbose bool) error {
 	}
 
 	// Find directories starting with r_
-	entries, err := os.ReadDir(inputDir)
+	entries, err := afero.ReadDir(fs, inputDir)
 	if err != nil {
 		return fmt.Errorf("read input dir: %w", err)
 	}
@@ -77,60 +141,124 @@ func processAll(inputDir string, concurrency int, verbose bool) error {
 		fmt.Printf("Found %d 'r_' folders to process\n", len(targets))
 	}
 
+	// globalSem caps the total number of in-flight processFile goroutines
+	// across every folder, so folderConcurrency*concurrency can't overrun
+	// the disk even when many folders are scheduled at once.
+	globalSem := make(chan struct{}, maxInFlight)
+
+	folderCh := make(chan string)
+	var wg sync.WaitGroup
+	for i := 0; i < folderConcurrency; i++ {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			for folder := range folderCh {
+				reporter.FolderStarted(folder)
+				if err := processFolder(fs, reporter, folder, concurrency, globalSem, hashNames, resume, disc); err != nil {
+					// Report error but continue with other folders
+					reporter.Error(folder, fmt.Errorf("processing error: %w", err))
+				}
+			}
+		}()
+	}
+
 	for _, folder := range targets {
-		if verbose {
-			fmt.Printf("Processing folder: %s\n", folder)
-		}
-		if err := processFolder(folder, concurrency, verbose); err != nil {
-			// Log error but continue with other folders
-			fmt.Fprintf(os.Stderr, "folder %s: processing error: %v\n", folder, err)
-		}
+		folderCh <- folder
 	}
+	close(folderCh)
+	wg.Wait()
 
 	return nil
 }
 
 // processFolder processes files inside a single folder concurrently, writes log, then renames folder.
-func processFolder(folder string, concurrency int, verbose bool) error {
-	dirEntries, err := os.ReadDir(folder)
-	if err != nil {
-		return fmt.Errorf("read folder: %w", err)
+func processFolder(fs afero.Fs, reporter Reporter, folder string, concurrency int, globalSem chan struct{}, hashNames []string, resume bool, disc DiscoveryOptions) error {
+	// The lockfile is an OS-level advisory lock guarding concurrent real
+	// invocations; it has no meaning against an in-memory or read-only Fs.
+	var lock *folderLock
+	if _, isRealFs := fs.(*afero.OsFs); isRealFs {
+		l, ok, err := acquireFolderLock(folder)
+		if err != nil {
+			return fmt.Errorf("acquire lock: %w", err)
+		}
+		if !ok {
+			reporter.Error(folder, fmt.Errorf("busy: lock held by another run, skipping"))
+			return nil
+		}
+		lock = l
 	}
-
-	// Collect file entries (skip nested directories)
-	var files []os.DirEntry
-	for _, de := range dirEntries {
-		if de.IsDir() {
-			continue
+	releaseLock := func() {
+		if lock != nil {
+			lock.Release()
+			lock = nil
 		}
-		files = append(files, de)
 	}
+	defer releaseLock()
 
-	if verbose {
-		fmt.Printf("  found %d files in %s\n", len(files), folder)
+	files, err := discoverFiles(fs, folder, disc)
+	if err != nil {
+		return err
+	}
+
+	// Build a manifest of previously successful entries from a prior crashed
+	// run's log.json or log.tmp, keyed by filename, so unchanged files can
+	// be skipped instead of re-hashed.
+	var manifest map[string]LogEntry
+	if resume {
+		manifest = loadResumeManifest(fs, folder)
 	}
 
 	// Result channel
 	resultsCh := make(chan LogEntry, len(files))
 
-	// Semaphore to limit concurrency
+	// Semaphore to limit concurrency within this folder
 	sem := make(chan struct{}, concurrency)
 
 	var wg sync.WaitGroup
-	for _, f := range files {
+	for _, df := range files {
+		filename := df.RelPath
+
+		if df.Skipped != "" {
+			entry := LogEntry{
+				Filename:  filename,
+				Status:    "skipped",
+				Error:     df.Skipped,
+				Timestamp: time.Now().UTC().Format(time.RFC3339),
+			}
+			wg.Add(1)
+			go func(entry LogEntry) {
+				defer wg.Done()
+				reporter.FileStarted(folder, entry.Filename)
+				resultsCh <- entry
+				reporter.FileFinished(folder, entry)
+			}(entry)
+			continue
+		}
+
+		if cached, ok := manifest[filename]; ok && resumeEntryMatches(cached, df.Info, hashNames) {
+			wg.Add(1)
+			go func(entry LogEntry) {
+				defer wg.Done()
+				reporter.FileStarted(folder, entry.Filename)
+				resultsCh <- entry
+				reporter.FileFinished(folder, entry)
+			}(cached)
+			continue
+		}
+
 		wg.Add(1)
-		sem <- struct{}{} // acquire
+		sem <- struct{}{}       // acquire per-folder slot
+		globalSem <- struct{}{} // acquire process-wide slot
 		// capture variables for goroutine
-		filename := f.Name()
 		go func(fname string) {
 			defer wg.Done()
-			defer func() { <-sem }() // release
+			defer func() { <-sem }()       // release per-folder slot
+			defer func() { <-globalSem }() // release process-wide slot
 
-			entry := processFile(filepath.Join(folder, fname), fname)
+			reporter.FileStarted(folder, fname)
+			entry := processFile(fs, filepath.Join(folder, filepath.FromSlash(fname)), fname, hashNames)
 			resultsCh <- entry
-			if verbose {
-				fmt.Printf("    processed %s -> %s\n", fname, entry.Status)
-			}
+			reporter.FileFinished(folder, entry)
 		}(filename)
 	}
 
@@ -150,37 +278,57 @@ func processFolder(folder string, concurrency int, verbose bool) error {
 	sort.Slice(results, func(i, j int) bool { return results[i].Filename < results[j].Filename })
 
 	// Determine overall success
-	allSuccess := true
+	summary := FolderSummary{TotalFiles: len(results), AllSuccess: true}
 	for _, r := range results {
-		if r.Status != "success" {
-			allSuccess = false
-			break
+		switch r.Status {
+		case "success":
+			summary.Succeeded++
+		case "skipped":
+			summary.Skipped++
+		default:
+			summary.Failed++
+			summary.AllSuccess = false
 		}
 	}
+	reporter.FolderFinished(folder, summary)
+
+	// -dry-run wraps fs in a ReadOnlyFs: hashing already ran above, but
+	// writing log.json and renaming the folder would just fail against a
+	// read-only Fs, so skip both and leave the folder untouched.
+	if _, isReadOnly := fs.(*afero.ReadOnlyFs); isReadOnly {
+		releaseLock()
+		return nil
+	}
 
 	// Write atomic log.tmp -> log.json
-	if err := writeLogAtomic(folder, results); err != nil {
+	if err := writeLogAtomic(fs, folder, results); err != nil {
 		// even if logging fails, try to rename to failure to avoid reprocessing in loop
-		fmt.Fprintf(os.Stderr, "failed to write log for %s: %v\n", folder, err)
+		reporter.Error(folder, fmt.Errorf("failed to write log: %w", err))
+		// release the lock before renaming so the lockfile doesn't get sealed inside the renamed folder
+		releaseLock()
 		// rename folder to failure
-		if renameErr := renameFolderSafe(folder, false); renameErr != nil {
+		if renameErr := renameFolderSafe(fs, folder, false); renameErr != nil {
 			return fmt.Errorf("logging error: %v; folder rename error: %v", err, renameErr)
 		}
 		return fmt.Errorf("failed to write log: %w", err)
 	}
 
+	// Release the lock before renaming so the lockfile doesn't get sealed inside the renamed folder
+	releaseLock()
+
 	// Finally rename directory based on success / failure
-	if err := renameFolderSafe(folder, allSuccess); err != nil {
+	if err := renameFolderSafe(fs, folder, summary.AllSuccess); err != nil {
 		return fmt.Errorf("rename folder: %w", err)
 	}
 
 	return nil
 }
 
-// processFile reads a file, calculates md5, timestamps results, handles errors gracefully.
-func processFile(fullpath, basename string) LogEntry {
+// processFile reads a file, computes the requested hashes in a single
+// streaming pass, timestamps results, handles errors gracefully.
+func processFile(fs afero.Fs, fullpath, basename string, hashNames []string) LogEntry {
 	now := time.Now().UTC().Format(time.RFC3339)
-	f, err := os.Open(fullpath)
+	f, err := fs.Open(fullpath)
 	if err != nil {
 		return LogEntry{
 			Filename:  basename,
@@ -191,9 +339,23 @@ func processFile(fullpath, basename string) LogEntry {
 	}
 	defer f.Close()
 
-	hash := md5.New()
-	// Copy file into hash efficiently (streams the file, avoids reading whole file into memory)
-	if _, err := io.Copy(hash, f); err != nil {
+	var size int64
+	var modTime time.Time
+	if info, statErr := f.Stat(); statErr == nil {
+		size = info.Size()
+		modTime = info.ModTime()
+	}
+
+	hashers := make(map[string]hash.Hash, len(hashNames))
+	writers := make([]io.Writer, 0, len(hashNames))
+	for _, name := range hashNames {
+		h := hashFactories[name]()
+		hashers[name] = h
+		writers = append(writers, h)
+	}
+
+	// Copy file into every hasher in one pass (streams the file, avoids reading whole file into memory)
+	if _, err := io.Copy(io.MultiWriter(writers...), f); err != nil {
 		return LogEntry{
 			Filename:  basename,
 			Status:    "error",
@@ -202,17 +364,27 @@ func processFile(fullpath, basename string) LogEntry {
 		}
 	}
 
-	sum := hash.Sum(nil)
-	return LogEntry{
+	hashes := make(map[string]string, len(hashers))
+	for name, h := range hashers {
+		hashes[name] = hex.EncodeToString(h.Sum(nil))
+	}
+
+	entry := LogEntry{
 		Filename:  basename,
 		Status:    "success",
-		MD5:       hex.EncodeToString(sum),
+		Hashes:    hashes,
 		Timestamp: now,
+		Size:      size,
+		ModTime:   modTime,
+	}
+	if sum, ok := hashes["md5"]; ok {
+		entry.MD5 = sum
 	}
+	return entry
 }
 
 // writeLogAtomic writes results to a temporary file in the folder and renames it to log.json.
-func writeLogAtomic(folder string, results []LogEntry) error {
+func writeLogAtomic(fs afero.Fs, folder string, results []LogEntry) error {
 	data, err := json.MarshalIndent(results, "", "  ")
 	if err != nil {
 		return fmt.Errorf("marshal results: %w", err)
@@ -221,8 +393,8 @@ func writeLogAtomic(folder string, results []LogEntry) error {
 	tmpPath := filepath.Join(folder, "log.tmp")
 	finalPath := filepath.Join(folder, "log.json")
 
-	// Use CreateTemp in same folder to ensure rename stays within same filesystem
-	tmpFile, err := os.Create(tmpPath)
+	// Use a tmp file in the same folder to ensure rename stays within the same filesystem
+	tmpFile, err := fs.Create(tmpPath)
 	if err != nil {
 		return fmt.Errorf("create tmp log: %w", err)
 	}
@@ -230,7 +402,7 @@ func writeLogAtomic(folder string, results []LogEntry) error {
 	// Write and ensure data flushed to disk
 	if _, err := tmpFile.Write(data); err != nil {
 		tmpFile.Close()
-		os.Remove(tmpPath)
+		fs.Remove(tmpPath)
 		return fmt.Errorf("write tmp log: %w", err)
 	}
 	if err := tmpFile.Sync(); err != nil {
@@ -242,9 +414,9 @@ func writeLogAtomic(folder string, results []LogEntry) error {
 	}
 
 	// Rename tmp to final (atomic on POSIX when on same filesystem)
-	if err := os.Rename(tmpPath, finalPath); err != nil {
+	if err := fs.Rename(tmpPath, finalPath); err != nil {
 		// cleanup tmp if rename fails
-		os.Remove(tmpPath)
+		fs.Remove(tmpPath)
 		return fmt.Errorf("rename tmp->final: %w", err)
 	}
 
@@ -253,7 +425,7 @@ func writeLogAtomic(folder string, results []LogEntry) error {
 
 // renameFolderSafe renames folder r_<id> -> d_<id> (allSuccess=true) or f_<id> (false).
 // If the destination already exists, it adds a timestamp suffix to avoid conflicts.
-func renameFolderSafe(src string, allSuccess bool) error {
+func renameFolderSafe(fs afero.Fs, src string, allSuccess bool) error {
 	base := filepath.Base(src)
 	parent := filepath.Dir(src)
 
@@ -281,14 +453,14 @@ func renameFolderSafe(src string, allSuccess bool) error {
 	target := filepath.Join(parent, targetPrefix+base)
 
 	// If target exists, add timestamp suffix to keep rename atomic and unique
-	if _, err := os.Stat(target); err == nil {
+	if _, err := fs.Stat(target); err == nil {
 		suffix := time.Now().UTC().Format("20060102T150405Z")
 		target = filepath.Join(parent, fmt.Sprintf("%s%s_%s", targetPrefix, base, suffix))
 	}
 
-	// Use os.Rename which is atomic if within same filesystem
-	if err := os.Rename(src, target); err != nil {
-		return fmt.Errorf("os.Rename %s -> %s: %w", src, target, err)
+	// Rename, atomic on POSIX when src/target are on the same real filesystem
+	if err := fs.Rename(src, target); err != nil {
+		return fmt.Errorf("rename %s -> %s: %w", src, target, err)
 	}
 	return nil
 }
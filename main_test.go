@@ -0,0 +1,523 @@
+// main_test.go
+package main
+
+import (
+	"encoding/json"
+	"fmt"
+	"os"
+	"path/filepath"
+	"sort"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/spf13/afero"
+)
+
+// faultyFs wraps an afero.Fs and lets tests force specific Open/Create
+// calls to fail, so processFolder's error branches can be exercised
+// without touching a real disk.
+type faultyFs struct {
+	afero.Fs
+	failOpen   func(name string) bool
+	failCreate func(name string) bool
+}
+
+func (f *faultyFs) Open(name string) (afero.File, error) {
+	if f.failOpen != nil && f.failOpen(name) {
+		return nil, fmt.Errorf("simulated open failure: %s", name)
+	}
+	return f.Fs.Open(name)
+}
+
+func (f *faultyFs) Create(name string) (afero.File, error) {
+	if f.failCreate != nil && f.failCreate(name) {
+		return nil, fmt.Errorf("simulated create failure: %s", name)
+	}
+	return f.Fs.Create(name)
+}
+
+func readLog(t *testing.T, fs afero.Fs, path string) []LogEntry {
+	t.Helper()
+	data, err := afero.ReadFile(fs, path)
+	if err != nil {
+		t.Fatalf("read log %s: %v", path, err)
+	}
+	var entries []LogEntry
+	if err := json.Unmarshal(data, &entries); err != nil {
+		t.Fatalf("unmarshal log %s: %v", path, err)
+	}
+	return entries
+}
+
+func TestProcessFolder_HappyPath(t *testing.T) {
+	fs := afero.NewMemMapFs()
+	folder := "/input/r_001"
+	if err := afero.WriteFile(fs, folder+"/a.txt", []byte("hello"), 0o644); err != nil {
+		t.Fatal(err)
+	}
+
+	globalSem := make(chan struct{}, 4)
+	if err := processFolder(fs, &TextReporter{}, folder, 2, globalSem, []string{"md5"}, true, DiscoveryOptions{}); err != nil {
+		t.Fatalf("processFolder: %v", err)
+	}
+
+	if ok, _ := afero.DirExists(fs, "/input/d_001"); !ok {
+		t.Fatalf("expected folder renamed to /input/d_001")
+	}
+
+	entries := readLog(t, fs, "/input/d_001/log.json")
+	if len(entries) != 1 || entries[0].Status != "success" || entries[0].MD5 == "" {
+		t.Fatalf("unexpected log entries: %+v", entries)
+	}
+}
+
+func TestProcessFolder_OpenFailure(t *testing.T) {
+	fs := &faultyFs{
+		Fs: afero.NewMemMapFs(),
+		failOpen: func(name string) bool {
+			return strings.HasSuffix(name, "bad.txt")
+		},
+	}
+	folder := "/input/r_002"
+	if err := afero.WriteFile(fs, folder+"/good.txt", []byte("hello"), 0o644); err != nil {
+		t.Fatal(err)
+	}
+	if err := afero.WriteFile(fs, folder+"/bad.txt", []byte("world"), 0o644); err != nil {
+		t.Fatal(err)
+	}
+
+	globalSem := make(chan struct{}, 4)
+	if err := processFolder(fs, &TextReporter{}, folder, 2, globalSem, []string{"md5"}, true, DiscoveryOptions{}); err != nil {
+		t.Fatalf("processFolder: %v", err)
+	}
+
+	if ok, _ := afero.DirExists(fs, "/input/f_002"); !ok {
+		t.Fatalf("expected folder renamed to /input/f_002 after a failed file")
+	}
+
+	entries := readLog(t, fs, "/input/f_002/log.json")
+	var sawError bool
+	for _, e := range entries {
+		if e.Filename == "bad.txt" {
+			if e.Status != "error" || e.Error == "" {
+				t.Fatalf("expected bad.txt to record an error, got %+v", e)
+			}
+			sawError = true
+		}
+	}
+	if !sawError {
+		t.Fatalf("bad.txt entry missing from log: %+v", entries)
+	}
+}
+
+func TestRenameFolderSafe_Collision(t *testing.T) {
+	fs := afero.NewMemMapFs()
+	src := "/input/r_003"
+	if err := fs.MkdirAll(src, 0o755); err != nil {
+		t.Fatal(err)
+	}
+	if err := fs.MkdirAll("/input/d_003", 0o755); err != nil {
+		t.Fatal(err)
+	}
+
+	if err := renameFolderSafe(fs, src, true); err != nil {
+		t.Fatalf("renameFolderSafe: %v", err)
+	}
+
+	entries, err := afero.ReadDir(fs, "/input")
+	if err != nil {
+		t.Fatal(err)
+	}
+	var sawSuffixed bool
+	for _, e := range entries {
+		if e.Name() != "d_003" && strings.HasPrefix(e.Name(), "d_003_") {
+			sawSuffixed = true
+		}
+	}
+	if !sawSuffixed {
+		t.Fatalf("expected a timestamp-suffixed d_003_* folder, got entries: %+v", entries)
+	}
+}
+
+func TestProcessFolder_LogWriteFailureRenamesToFailure(t *testing.T) {
+	fs := &faultyFs{
+		Fs: afero.NewMemMapFs(),
+		failCreate: func(name string) bool {
+			return strings.HasSuffix(name, "log.tmp")
+		},
+	}
+	folder := "/input/r_004"
+	if err := afero.WriteFile(fs, folder+"/a.txt", []byte("hello"), 0o644); err != nil {
+		t.Fatal(err)
+	}
+
+	globalSem := make(chan struct{}, 4)
+	err := processFolder(fs, &TextReporter{}, folder, 2, globalSem, []string{"md5"}, true, DiscoveryOptions{})
+	if err == nil {
+		t.Fatalf("expected an error from a failed log write")
+	}
+
+	if ok, _ := afero.DirExists(fs, "/input/f_004"); !ok {
+		t.Fatalf("expected folder renamed to /input/f_004 despite the log write failure")
+	}
+}
+
+func TestProcessFolder_ResumeSkipsUnchangedFile(t *testing.T) {
+	fs := afero.NewMemMapFs()
+	folder := "/input/r_005"
+	if err := afero.WriteFile(fs, folder+"/a.txt", []byte("hello"), 0o644); err != nil {
+		t.Fatal(err)
+	}
+
+	info, err := fs.Stat(folder + "/a.txt")
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	// Seed a prior crashed-run log.json recording a bogus MD5 for the
+	// unchanged file; resume should trust it instead of re-hashing.
+	prior := []LogEntry{{
+		Filename: "a.txt",
+		Status:   "success",
+		MD5:      "deadbeefdeadbeefdeadbeefdeadbeef",
+		Hashes:   map[string]string{"md5": "deadbeefdeadbeefdeadbeefdeadbeef"},
+		Size:     info.Size(),
+		ModTime:  info.ModTime(),
+	}}
+	data, err := json.Marshal(prior)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err := afero.WriteFile(fs, folder+"/log.json", data, 0o644); err != nil {
+		t.Fatal(err)
+	}
+
+	globalSem := make(chan struct{}, 4)
+	if err := processFolder(fs, &TextReporter{}, folder, 2, globalSem, []string{"md5"}, true, DiscoveryOptions{}); err != nil {
+		t.Fatalf("processFolder: %v", err)
+	}
+
+	entries := readLog(t, fs, "/input/d_005/log.json")
+	if len(entries) != 1 || entries[0].MD5 != "deadbeefdeadbeefdeadbeefdeadbeef" {
+		t.Fatalf("expected cached MD5 to be reused, got %+v", entries)
+	}
+}
+
+func TestProcessFolder_ResumeRehashesChangedFile(t *testing.T) {
+	fs := afero.NewMemMapFs()
+	folder := "/input/r_006"
+	if err := afero.WriteFile(fs, folder+"/a.txt", []byte("hello"), 0o644); err != nil {
+		t.Fatal(err)
+	}
+
+	// Seed a prior log.json whose recorded size no longer matches the
+	// current file, forcing a real re-hash despite -resume.
+	prior := []LogEntry{{
+		Filename: "a.txt",
+		Status:   "success",
+		MD5:      "deadbeefdeadbeefdeadbeefdeadbeef",
+		Hashes:   map[string]string{"md5": "deadbeefdeadbeefdeadbeefdeadbeef"},
+		Size:     999,
+	}}
+	data, err := json.Marshal(prior)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err := afero.WriteFile(fs, folder+"/log.json", data, 0o644); err != nil {
+		t.Fatal(err)
+	}
+
+	globalSem := make(chan struct{}, 4)
+	if err := processFolder(fs, &TextReporter{}, folder, 2, globalSem, []string{"md5"}, true, DiscoveryOptions{}); err != nil {
+		t.Fatalf("processFolder: %v", err)
+	}
+
+	entries := readLog(t, fs, "/input/d_006/log.json")
+	if len(entries) != 1 || entries[0].MD5 == "deadbeefdeadbeefdeadbeefdeadbeef" {
+		t.Fatalf("expected a.txt to be re-hashed, got %+v", entries)
+	}
+}
+
+// TestDiscoverFiles_DirSymlink exercises -recursive against a real OS
+// filesystem, since MemMapFs has no notion of symlinks. Without
+// -follow-symlinks, a symlinked subdirectory must be recorded as skipped
+// rather than handed to processFile as if it were a regular file.
+func TestDiscoverFiles_DirSymlink(t *testing.T) {
+	root := t.TempDir()
+	folder := filepath.Join(root, "r_009")
+	target := filepath.Join(folder, "nested_target")
+	if err := os.MkdirAll(target, 0o755); err != nil {
+		t.Fatal(err)
+	}
+	if err := os.WriteFile(filepath.Join(target, "b.txt"), []byte("world"), 0o644); err != nil {
+		t.Fatal(err)
+	}
+	if err := os.MkdirAll(folder, 0o755); err != nil {
+		t.Fatal(err)
+	}
+	if err := os.WriteFile(filepath.Join(folder, "a.txt"), []byte("hello"), 0o644); err != nil {
+		t.Fatal(err)
+	}
+	if err := os.Symlink(target, filepath.Join(folder, "nested_link")); err != nil {
+		t.Skipf("symlinks not supported on this platform: %v", err)
+	}
+
+	fs := afero.NewOsFs()
+
+	files, err := discoverFiles(fs, folder, DiscoveryOptions{Recursive: true})
+	if err != nil {
+		t.Fatalf("discoverFiles: %v", err)
+	}
+	var sawSkippedSymlink bool
+	for _, df := range files {
+		if df.RelPath == "nested_link" {
+			if df.Skipped == "" {
+				t.Fatalf("expected nested_link to be recorded as skipped, got %+v", df)
+			}
+			sawSkippedSymlink = true
+		}
+		if strings.HasPrefix(df.RelPath, "nested_link/") {
+			t.Fatalf("expected no descent into the unfollowed symlink, found %q", df.RelPath)
+		}
+	}
+	if !sawSkippedSymlink {
+		t.Fatalf("expected nested_link itself to appear as a skipped entry, got %+v", files)
+	}
+
+	following, err := discoverFiles(fs, folder, DiscoveryOptions{Recursive: true, FollowSymlinks: true})
+	if err != nil {
+		t.Fatalf("discoverFiles with -follow-symlinks: %v", err)
+	}
+	var sawNestedFile bool
+	for _, df := range following {
+		if df.RelPath == "nested_link/b.txt" {
+			if df.Skipped != "" {
+				t.Fatalf("expected nested_link/b.txt to be processed, got %+v", df)
+			}
+			sawNestedFile = true
+		}
+	}
+	if !sawNestedFile {
+		t.Fatalf("expected -follow-symlinks to traverse nested_link, got %+v", following)
+	}
+}
+
+// TestDiscoverFiles_FollowSymlinksRefusesEscape ensures a -follow-symlinks
+// traversal never leaves the r_* folder root, even when asked to.
+func TestDiscoverFiles_FollowSymlinksRefusesEscape(t *testing.T) {
+	root := t.TempDir()
+	outside := filepath.Join(root, "outside")
+	if err := os.MkdirAll(outside, 0o755); err != nil {
+		t.Fatal(err)
+	}
+	if err := os.WriteFile(filepath.Join(outside, "secret.txt"), []byte("nope"), 0o644); err != nil {
+		t.Fatal(err)
+	}
+
+	folder := filepath.Join(root, "r_010")
+	if err := os.MkdirAll(folder, 0o755); err != nil {
+		t.Fatal(err)
+	}
+	if err := os.Symlink(outside, filepath.Join(folder, "escape_link")); err != nil {
+		t.Skipf("symlinks not supported on this platform: %v", err)
+	}
+
+	files, err := discoverFiles(afero.NewOsFs(), folder, DiscoveryOptions{Recursive: true, FollowSymlinks: true})
+	if err != nil {
+		t.Fatalf("discoverFiles: %v", err)
+	}
+	for _, df := range files {
+		if strings.HasPrefix(df.RelPath, "escape_link/") {
+			t.Fatalf("expected no descent outside the folder root, found %q", df.RelPath)
+		}
+		if df.RelPath == "escape_link" && df.Skipped == "" {
+			t.Fatalf("expected escape_link to be recorded as skipped, got %+v", df)
+		}
+	}
+}
+
+// TestDiscoverFiles_FollowSymlinksBreaksCycle ensures a self-referential
+// symlink terminates the walk instead of recursing indefinitely.
+func TestDiscoverFiles_FollowSymlinksBreaksCycle(t *testing.T) {
+	root := t.TempDir()
+	folder := filepath.Join(root, "r_011")
+	sub := filepath.Join(folder, "sub")
+	if err := os.MkdirAll(sub, 0o755); err != nil {
+		t.Fatal(err)
+	}
+	if err := os.WriteFile(filepath.Join(folder, "a.txt"), []byte("hello"), 0o644); err != nil {
+		t.Fatal(err)
+	}
+	if err := os.Symlink(".", filepath.Join(sub, "loop")); err != nil {
+		t.Skipf("symlinks not supported on this platform: %v", err)
+	}
+
+	done := make(chan struct{})
+	var files []discoveredFile
+	var err error
+	go func() {
+		files, err = discoverFiles(afero.NewOsFs(), folder, DiscoveryOptions{Recursive: true, FollowSymlinks: true})
+		close(done)
+	}()
+	select {
+	case <-done:
+	case <-time.After(5 * time.Second):
+		t.Fatal("discoverFiles did not terminate on a self-referential symlink")
+	}
+	if err != nil {
+		t.Fatalf("discoverFiles: %v", err)
+	}
+
+	// The symlink's first occurrence (sub/loop) legitimately resolves back
+	// to sub itself, which is still under the folder root, so it's
+	// followed once; only its repeat appearance one level down
+	// (sub/loop/loop) revisits an already-seen real directory and gets
+	// skipped, which is what stops the walk from recursing forever.
+	var sawCycleSkip bool
+	for _, df := range files {
+		if df.RelPath == "sub/loop/loop" {
+			sawCycleSkip = true
+			if df.Skipped == "" {
+				t.Fatalf("expected sub/loop/loop to be recorded as skipped, got %+v", df)
+			}
+		}
+		if strings.Count(df.RelPath, "/loop") > 2 {
+			t.Fatalf("expected the walk to stop after one repeated visit, got %q", df.RelPath)
+		}
+	}
+	if !sawCycleSkip {
+		t.Fatalf("expected sub/loop/loop to appear as a skipped entry, got %+v", files)
+	}
+}
+
+// TestAcquireFolderLock_SecondAcquireFails exercises the lockfile against a
+// real directory: flock locks are keyed by open file description, so a
+// second acquireFolderLock on the same folder while the first is still
+// held must report ok=false rather than blocking or erroring.
+func TestAcquireFolderLock_SecondAcquireFails(t *testing.T) {
+	folder := t.TempDir()
+
+	lock1, ok1, err := acquireFolderLock(folder)
+	if err != nil || !ok1 {
+		t.Fatalf("first acquireFolderLock: ok=%v err=%v", ok1, err)
+	}
+	defer lock1.Release()
+
+	lock2, ok2, err := acquireFolderLock(folder)
+	if err != nil {
+		t.Fatalf("second acquireFolderLock returned an unexpected error: %v", err)
+	}
+	if ok2 {
+		lock2.Release()
+		t.Fatalf("expected second acquireFolderLock to fail while the first is held")
+	}
+
+	lock1.Release()
+	lock3, ok3, err := acquireFolderLock(folder)
+	if err != nil || !ok3 {
+		t.Fatalf("acquireFolderLock after release: ok=%v err=%v", ok3, err)
+	}
+	lock3.Release()
+}
+
+// TestProcessFolder_SkipsWhenLockHeld exercises processFolder's busy path
+// against a real OsFs folder: a folder already locked by another run must
+// be left untouched and reported without being treated as a processing
+// error.
+func TestProcessFolder_SkipsWhenLockHeld(t *testing.T) {
+	parent := t.TempDir()
+	folder := filepath.Join(parent, "r_012")
+	if err := os.MkdirAll(folder, 0o755); err != nil {
+		t.Fatal(err)
+	}
+	if err := os.WriteFile(filepath.Join(folder, "a.txt"), []byte("hello"), 0o644); err != nil {
+		t.Fatal(err)
+	}
+
+	held, ok, err := acquireFolderLock(folder)
+	if err != nil || !ok {
+		t.Fatalf("acquireFolderLock: ok=%v err=%v", ok, err)
+	}
+	defer held.Release()
+
+	fs := afero.NewOsFs()
+	globalSem := make(chan struct{}, 4)
+	if err := processFolder(fs, &TextReporter{}, folder, 2, globalSem, []string{"md5"}, true, DiscoveryOptions{}); err != nil {
+		t.Fatalf("processFolder: expected a busy folder to be skipped without error, got %v", err)
+	}
+
+	if ok, _ := afero.Exists(fs, filepath.Join(folder, "log.json")); ok {
+		t.Fatalf("expected a busy folder to be left untouched, found log.json")
+	}
+	entries, err := afero.ReadDir(fs, parent)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(entries) != 1 || entries[0].Name() != "r_012" {
+		t.Fatalf("expected folder to remain r_012 while locked, got %+v", entries)
+	}
+}
+
+func TestProcessFolder_DryRunSkipsWriteAndRename(t *testing.T) {
+	base := afero.NewMemMapFs()
+	folder := "/input/r_008"
+	if err := afero.WriteFile(base, folder+"/a.txt", []byte("hello"), 0o644); err != nil {
+		t.Fatal(err)
+	}
+	fs := afero.NewReadOnlyFs(base)
+
+	globalSem := make(chan struct{}, 4)
+	if err := processFolder(fs, &TextReporter{}, folder, 2, globalSem, []string{"md5"}, false, DiscoveryOptions{}); err != nil {
+		t.Fatalf("processFolder: %v", err)
+	}
+
+	if ok, _ := afero.DirExists(fs, folder); !ok {
+		t.Fatalf("expected -dry-run to leave %s in place", folder)
+	}
+	if ok, _ := afero.Exists(fs, folder+"/log.json"); ok {
+		t.Fatalf("expected -dry-run not to write log.json")
+	}
+}
+
+func TestProcessFolder_RecursiveWithIncludeExclude(t *testing.T) {
+	fs := afero.NewMemMapFs()
+	folder := "/input/r_007"
+	if err := afero.WriteFile(fs, folder+"/a.txt", []byte("hello"), 0o644); err != nil {
+		t.Fatal(err)
+	}
+	if err := afero.WriteFile(fs, folder+"/nested/b.txt", []byte("world"), 0o644); err != nil {
+		t.Fatal(err)
+	}
+	if err := afero.WriteFile(fs, folder+"/nested/skip.log", []byte("ignored"), 0o644); err != nil {
+		t.Fatal(err)
+	}
+
+	include, err := compileGlobs("**/*.txt")
+	if err != nil {
+		t.Fatal(err)
+	}
+	exclude, err := compileGlobs("nested/skip.log")
+	if err != nil {
+		t.Fatal(err)
+	}
+	disc := DiscoveryOptions{Recursive: true, Include: include, Exclude: exclude}
+
+	globalSem := make(chan struct{}, 4)
+	if err := processFolder(fs, &TextReporter{}, folder, 2, globalSem, []string{"md5"}, false, disc); err != nil {
+		t.Fatalf("processFolder: %v", err)
+	}
+
+	entries := readLog(t, fs, "/input/d_007/log.json")
+	if len(entries) != 2 {
+		t.Fatalf("expected exactly the two .txt files, got %+v", entries)
+	}
+	var names []string
+	for _, e := range entries {
+		names = append(names, e.Filename)
+	}
+	sort.Strings(names)
+	if names[0] != "a.txt" || names[1] != "nested/b.txt" {
+		t.Fatalf("expected relative paths a.txt and nested/b.txt, got %v", names)
+	}
+}
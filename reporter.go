@@ -0,0 +1,122 @@
+// reporter.go
+package main
+
+import (
+	"encoding/json"
+	"fmt"
+	"io"
+	"os"
+	"sync"
+	"time"
+)
+
+// FolderSummary describes the outcome of processing one folder, reported
+// once all of its files have finished.
+type FolderSummary struct {
+	TotalFiles int  `json:"total_files"`
+	Succeeded  int  `json:"succeeded"`
+	Skipped    int  `json:"skipped"`
+	Failed     int  `json:"failed"`
+	AllSuccess bool `json:"all_success"`
+}
+
+// Reporter decouples progress/event reporting from processFolder and
+// processFile so the same processing code can drive a human-readable
+// stream, a machine-readable one, or both.
+type Reporter interface {
+	FolderStarted(folder string)
+	FolderFinished(folder string, summary FolderSummary)
+	FileStarted(folder, filename string)
+	FileFinished(folder string, entry LogEntry)
+	Error(folder string, err error)
+}
+
+// TextReporter writes human-readable output: progress lines on stdout when
+// Verbose is set, and errors on stderr. The per-folder "found N files" line
+// is printed after the folder's files have been processed, together with
+// its succeeded/skipped/failed counts, not before processing starts.
+type TextReporter struct {
+	Verbose bool
+}
+
+func (r *TextReporter) FolderStarted(folder string) {
+	if r.Verbose {
+		fmt.Printf("Processing folder: %s\n", folder)
+	}
+}
+
+func (r *TextReporter) FolderFinished(folder string, summary FolderSummary) {
+	if r.Verbose {
+		fmt.Printf("  found %d files in %s (%d succeeded, %d skipped, %d failed)\n",
+			summary.TotalFiles, folder, summary.Succeeded, summary.Skipped, summary.Failed)
+	}
+}
+
+func (r *TextReporter) FileStarted(folder, filename string) {}
+
+func (r *TextReporter) FileFinished(folder string, entry LogEntry) {
+	if r.Verbose {
+		fmt.Printf("    processed %s -> %s\n", entry.Filename, entry.Status)
+	}
+}
+
+func (r *TextReporter) Error(folder string, err error) {
+	fmt.Fprintf(os.Stderr, "folder %s: %v\n", folder, err)
+}
+
+// jsonEvent is the newline-delimited JSON shape written by JSONReporter.
+type jsonEvent struct {
+	Type      string         `json:"type"`
+	Timestamp time.Time      `json:"timestamp"`
+	Folder    string         `json:"folder,omitempty"`
+	Filename  string         `json:"filename,omitempty"`
+	Entry     *LogEntry      `json:"entry,omitempty"`
+	Summary   *FolderSummary `json:"summary,omitempty"`
+	Error     string         `json:"error,omitempty"`
+}
+
+// JSONReporter writes one JSON object per line to w, suitable for driving
+// a TUI or CI dashboard instead of scraping stdout/stderr text.
+type JSONReporter struct {
+	mu sync.Mutex
+	w  io.Writer
+}
+
+func NewJSONReporter(w io.Writer) *JSONReporter {
+	return &JSONReporter{w: w}
+}
+
+func (r *JSONReporter) emit(ev jsonEvent) {
+	ev.Timestamp = time.Now().UTC()
+	data, err := json.Marshal(ev)
+	if err != nil {
+		return
+	}
+	data = append(data, '\n')
+
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	r.w.Write(data)
+}
+
+func (r *JSONReporter) FolderStarted(folder string) {
+	r.emit(jsonEvent{Type: "folder_started", Folder: folder})
+}
+
+func (r *JSONReporter) FolderFinished(folder string, summary FolderSummary) {
+	s := summary
+	r.emit(jsonEvent{Type: "folder_finished", Folder: folder, Summary: &s})
+}
+
+func (r *JSONReporter) FileStarted(folder, filename string) {
+	r.emit(jsonEvent{Type: "file_started", Folder: folder, Filename: filename})
+}
+
+func (r *JSONReporter) FileFinished(folder string, entry LogEntry) {
+	e := entry
+	r.emit(jsonEvent{Type: "file_finished", Folder: folder, Filename: entry.Filename, Entry: &e})
+}
+
+func (r *JSONReporter) Error(folder string, err error) {
+	r.emit(jsonEvent{Type: "error", Folder: folder, Error: err.Error()})
+}
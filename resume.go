@@ -0,0 +1,55 @@
+// resume.go
+package main
+
+import (
+	"encoding/json"
+	"os"
+	"path/filepath"
+
+	"github.com/spf13/afero"
+)
+
+// loadResumeManifest reads a prior log.json or log.tmp left behind in
+// folder by a crashed run and returns its successful entries keyed by
+// filename. It returns nil if neither file is present or parseable.
+func loadResumeManifest(fs afero.Fs, folder string) map[string]LogEntry {
+	for _, name := range []string{"log.json", "log.tmp"} {
+		data, err := afero.ReadFile(fs, filepath.Join(folder, name))
+		if err != nil {
+			continue
+		}
+
+		var prior []LogEntry
+		if err := json.Unmarshal(data, &prior); err != nil {
+			continue
+		}
+
+		manifest := make(map[string]LogEntry, len(prior))
+		for _, entry := range prior {
+			if entry.Status == "success" {
+				manifest[entry.Filename] = entry
+			}
+		}
+		return manifest
+	}
+	return nil
+}
+
+// resumeEntryMatches reports whether a cached LogEntry can be reused for
+// info instead of re-hashing: the file's current size and mtime must
+// match what was recorded, and the cache must already have every
+// requested hash algorithm.
+func resumeEntryMatches(cached LogEntry, info os.FileInfo, hashNames []string) bool {
+	if cached.Size != info.Size() || !cached.ModTime.Equal(info.ModTime()) {
+		return false
+	}
+	for _, name := range hashNames {
+		if name == "md5" && cached.MD5 != "" {
+			continue
+		}
+		if cached.Hashes[name] == "" {
+			return false
+		}
+	}
+	return true
+}